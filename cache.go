@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// CacheEntry is the last-seen sample for a COUNTER/DERIVE metric, used to
+// compute a rate between samples.
+type CacheEntry struct {
+	Timestamp int64
+	Value     float64
+}
+
+// NormalizerCache holds the last-seen CacheEntry for each COUNTER/DERIVE
+// metric. It bounds memory use two ways: entries older than ttl are evicted
+// by a background janitor, and the total entry count is capped at max via
+// LRU eviction. It is safe for concurrent use.
+type NormalizerCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*cacheElement
+	order   *list.List // front = most recently used
+}
+
+type cacheElement struct {
+	key       string
+	entry     CacheEntry
+	updatedAt time.Time
+	elem      *list.Element
+}
+
+// NewNormalizerCache builds a cache that evicts entries idle for longer than
+// ttl (no expiry if ttl <= 0) and caps itself at max entries (no cap if
+// max <= 0) by evicting the least-recently-used entry.
+func NewNormalizerCache(ttl time.Duration, max int) *NormalizerCache {
+	return &NormalizerCache{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*cacheElement),
+		order:   list.New(),
+	}
+}
+
+// Get returns the entry cached under key, if present and not expired.
+func (c *NormalizerCache) Get(key string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if c.ttl > 0 && time.Since(el.updatedAt) > c.ttl {
+		c.removeLocked(el)
+		return CacheEntry{}, false
+	}
+	c.order.MoveToFront(el.elem)
+	return el.entry, true
+}
+
+// Set stores entry under key, evicting the least-recently-used entry first
+// if the cache is at capacity.
+func (c *NormalizerCache) Set(key string, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.entry = entry
+		el.updatedAt = time.Now()
+		c.order.MoveToFront(el.elem)
+		return
+	}
+
+	el := &cacheElement{key: key, entry: entry, updatedAt: time.Now()}
+	el.elem = c.order.PushFront(el)
+	c.entries[key] = el
+
+	if c.max > 0 && len(c.entries) > c.max {
+		if oldest := c.order.Back(); oldest != nil {
+			c.removeLocked(oldest.Value.(*cacheElement))
+		}
+	}
+}
+
+// removeLocked removes el from both the entry map and the LRU list. Callers
+// must hold c.mu.
+func (c *NormalizerCache) removeLocked(el *cacheElement) {
+	delete(c.entries, el.key)
+	c.order.Remove(el.elem)
+}
+
+// Len returns the current number of cached entries.
+func (c *NormalizerCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// evictExpired drops every entry idle for longer than ttl.
+func (c *NormalizerCache) evictExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, el := range c.entries {
+		if now.Sub(el.updatedAt) > c.ttl {
+			c.removeLocked(el)
+		}
+	}
+}
+
+// janitor evicts expired entries every interval, for the lifetime of the
+// process. It's a no-op when the cache has no TTL.
+func (c *NormalizerCache) janitor(interval time.Duration) {
+	if c.ttl <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		c.evictExpired()
+	}
+}
+
+// normalize computes the rate-per-second for a COUNTER/DERIVE sample given
+// the last value cached under key, then updates the cache with the raw
+// sample as a side effect. monotonic should be true for COUNTER values,
+// which only ever increase between samples (a decrease means the counter
+// wrapped or the source reset), and false for DERIVE values, which are
+// signed and can legitimately decrease. ok is false when there's no prior
+// sample to diff against, or when a monotonic sample decreased — in both
+// cases the sample should be dropped rather than used to emit a bogus rate.
+func (c *NormalizerCache) normalize(key string, value float64, timestamp int64, monotonic bool) (normalized float64, ok bool) {
+	before, hadPrior := c.Get(key)
+	c.Set(key, CacheEntry{Timestamp: timestamp, Value: value})
+
+	if !hadPrior || math.IsNaN(before.Value) || (monotonic && value < before.Value) {
+		return 0, false
+	}
+	if timestamp-before.Timestamp > 0 {
+		return (value - before.Value) / float64((timestamp-before.Timestamp)/1000), true
+	}
+	return value - before.Value, true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeRate(t *testing.T) {
+	c := NewNormalizerCache(0, 0)
+
+	if _, ok := c.normalize("k", 100, 1000, true); ok {
+		t.Fatal("expected no prior sample to report not-ok")
+	}
+
+	// ten seconds later, counter advanced by 50 -> 5/s
+	got, ok := c.normalize("k", 150, 11000, true)
+	if !ok {
+		t.Fatal("expected a rate with a prior sample")
+	}
+	if got != 5 {
+		t.Errorf("got %v, want 5", got)
+	}
+}
+
+func TestNormalizeZeroInterval(t *testing.T) {
+	c := NewNormalizerCache(0, 0)
+	c.normalize("k", 100, 1000, true)
+
+	// a second sample with the exact same timestamp must not divide by zero
+	got, ok := c.normalize("k", 140, 1000, true)
+	if !ok {
+		t.Fatal("expected a value for a zero-interval sample")
+	}
+	if got != 40 {
+		t.Errorf("got %v, want 40 (plain delta, no division)", got)
+	}
+}
+
+func TestNormalizeCounterWrap(t *testing.T) {
+	c := NewNormalizerCache(0, 0)
+	c.normalize("k", 100, 1000, true)
+
+	// counter wrapped around (or the source reset) - value dropped below
+	// the prior sample, so this must be rejected rather than produce a huge
+	// negative rate
+	if _, ok := c.normalize("k", 10, 11000, true); ok {
+		t.Fatal("expected counter wrap-around to be rejected")
+	}
+
+	// the next sample should resume normalizing against the post-wrap value
+	got, ok := c.normalize("k", 30, 21000, true)
+	if !ok {
+		t.Fatal("expected a rate once samples are increasing again")
+	}
+	if got != 2 {
+		t.Errorf("got %v, want 2", got)
+	}
+}
+
+func TestNormalizeDeriveDecrease(t *testing.T) {
+	c := NewNormalizerCache(0, 0)
+	c.normalize("k", 100, 1000, false)
+
+	// DERIVE is signed: a legitimate decrease must not be treated as a
+	// counter wrap-around and dropped
+	got, ok := c.normalize("k", 60, 11000, false)
+	if !ok {
+		t.Fatal("expected a DERIVE decrease to be accepted")
+	}
+	if got != -4 {
+		t.Errorf("got %v, want -4", got)
+	}
+}
+
+func TestCacheTTLExpiry(t *testing.T) {
+	c := NewNormalizerCache(10*time.Millisecond, 0)
+	c.Set("k", CacheEntry{Timestamp: 1000, Value: 42})
+
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("expected entry to still be present immediately after Set")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := c.Get("k"); ok {
+		t.Error("expected entry to have expired after the TTL elapsed")
+	}
+	if got := c.Len(); got != 0 {
+		t.Errorf("Len() = %d, want 0 after expiry", got)
+	}
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	c := NewNormalizerCache(0, 2)
+	c.Set("a", CacheEntry{Value: 1})
+	c.Set("b", CacheEntry{Value: 2})
+
+	// touch "a" so "b" becomes the least-recently-used entry
+	c.Get("a")
+	c.Set("c", CacheEntry{Value: 3})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected least-recently-used entry to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected recently-used entry to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("expected newly-inserted entry to be present")
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	carbonDialTimeout  = 5 * time.Second
+	carbonWriteTimeout = 5 * time.Second
+	carbonMaxBackoff   = time.Minute
+)
+
+// CarbonOutput writes points to a Graphite/Carbon server using the plaintext
+// protocol: one "metric.path value timestamp\n" line per point.
+type CarbonOutput struct {
+	address string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	backoff time.Duration
+}
+
+// NewCarbonOutput builds a CarbonOutput for a "-output=carbon://<address>"
+// spec; the connection itself is established lazily on first Write.
+func NewCarbonOutput(address string) (*CarbonOutput, error) {
+	if address == "" {
+		return nil, fmt.Errorf("carbon output requires a host:port address")
+	}
+	return &CarbonOutput{address: address}, nil
+}
+
+func (c *CarbonOutput) Name() string { return "carbon" }
+
+func (c *CarbonOutput) Write(points []*Point) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.connectLocked()
+	if err != nil {
+		return err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(carbonWriteTimeout))
+	for _, p := range points {
+		if _, err := conn.Write([]byte(carbonLine(p))); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// connectLocked returns the current connection, (re)dialing with an
+// increasing backoff if it's been closed or never opened. Callers must hold
+// c.mu.
+func (c *CarbonOutput) connectLocked() (net.Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if c.backoff > 0 {
+		time.Sleep(c.backoff)
+	}
+
+	conn, err := net.DialTimeout("tcp", c.address, carbonDialTimeout)
+	if err != nil {
+		if c.backoff == 0 {
+			c.backoff = time.Second
+		} else if c.backoff < carbonMaxBackoff {
+			c.backoff *= 2
+		}
+		return nil, fmt.Errorf("carbon: dial %s: %w", c.address, err)
+	}
+
+	c.backoff = 0
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *CarbonOutput) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// carbonLine formats a point as a Carbon plaintext line: its dotted metric
+// path, the "value" field, and the timestamp in seconds.
+func carbonLine(p *Point) string {
+	return fmt.Sprintf("%s %v %d\n", carbonPath(p), p.Fields["value"], p.Timestamp/1000)
+}
+
+// carbonPath flattens a tagged Point into a dotted Graphite metric path: the
+// "host" tag (if present), the point name, then any remaining tag values in
+// key-sorted order for determinism.
+func carbonPath(p *Point) string {
+	segments := make([]string, 0, len(p.Tags)+1)
+	if host, ok := p.Tags["host"]; ok {
+		segments = append(segments, host)
+	}
+	segments = append(segments, p.Name)
+
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		if k == "host" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		segments = append(segments, p.Tags[k])
+	}
+
+	return strings.Join(segments, ".")
+}
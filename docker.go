@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/samalba/dockerclient"
+)
+
+// dockerT resolves collectd hostnames that are actually container IDs back
+// to their human-readable container name, and caches each container's
+// labels for optional use as point tags.
+type dockerT struct {
+	sync.Mutex
+	client *dockerclient.DockerClient
+	names  map[string]string
+	labels map[string]map[string]string
+}
+
+func (d *dockerT) updateNames() error {
+	containers, err := d.client.ListContainers(true, true, "")
+	if err != nil {
+		return err
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.names = make(map[string]string)
+	d.labels = make(map[string]map[string]string)
+	for _, c := range containers {
+		info, err := d.client.InspectContainer(c.Id)
+		if err != nil {
+			return err
+		}
+
+		if info.State.Running {
+			d.names[c.Id] = strings.TrimPrefix(c.Names[0], "/")
+			d.labels[c.Id] = info.Config.Labels
+		}
+	}
+
+	return nil
+}
+
+// labelTags returns the subset of containerID's labels that are configured
+// (via -docker-label-tags and -docker-label-prefix) to be attached as point
+// tags, or nil if none qualify.
+func (d *dockerT) labelTags(containerID string) map[string]string {
+	d.Lock()
+	labels := d.labels[containerID]
+	d.Unlock()
+
+	if len(labels) == 0 || (len(dockerLabelAllow) == 0 && *dockerLabelPrefix == "") {
+		return nil
+	}
+
+	tags := make(map[string]string)
+	for k, v := range labels {
+		_, allowed := dockerLabelAllow[k]
+		if allowed || (*dockerLabelPrefix != "" && strings.HasPrefix(k, *dockerLabelPrefix)) {
+			tags[k] = v
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
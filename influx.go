@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+
+	influxdb "github.com/influxdb/influxdb/client"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// InfluxWriter writes a batch of points to an InfluxDB backend, hiding the
+// differences between the 1.x line-protocol API and the 2.x HTTP write API
+// behind a single interface.
+type InfluxWriter interface {
+	Write(points []*write.Point) error
+	Close()
+}
+
+// InfluxOutput adapts the output-agnostic Point type to an InfluxWriter, so
+// InfluxDB can be registered as one Output among several via -output.
+type InfluxOutput struct {
+	writer InfluxWriter
+}
+
+// newInfluxOutput builds the InfluxOutput for a "-output=influxdb://<address>"
+// spec. The address is optional; when omitted, the -influx-* flags are used
+// as-is, preserving the pre-Output behavior.
+func newInfluxOutput(address string) (*InfluxOutput, error) {
+	hostAddr := *host
+	if address != "" {
+		hostAddr = address
+	}
+
+	var w InfluxWriter
+	var err error
+	if *influxToken != "" {
+		url := *influxURL
+		if url == "" {
+			url = *influxScheme + "://" + hostAddr
+		}
+		w = NewInfluxV2Writer(url, *influxToken, *influxOrg, *influxBucket)
+	} else {
+		w, err = NewInfluxV1Writer(hostAddr, *username, *password, *database, *https)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &InfluxOutput{writer: w}, nil
+}
+
+func (o *InfluxOutput) Name() string { return "influxdb" }
+
+func (o *InfluxOutput) Write(points []*Point) error {
+	wps := make([]*write.Point, 0, len(points))
+	for _, p := range points {
+		wps = append(wps, write.NewPoint(p.Name, p.Tags, p.Fields, timeFromMillis(p.Timestamp)))
+	}
+	return o.writer.Write(wps)
+}
+
+func (o *InfluxOutput) Close() {
+	o.writer.Close()
+}
+
+// InfluxV1Writer writes points to an InfluxDB 1.x server using the legacy
+// line-protocol client, flattening each *write.Point's tags and fields back
+// down into the column-based influxdb.Series shape it expects.
+type InfluxV1Writer struct {
+	client *influxdb.Client
+}
+
+// NewInfluxV1Writer connects to a legacy (pre-2.0) InfluxDB server.
+func NewInfluxV1Writer(host, username, password, database string, isSecure bool) (*InfluxV1Writer, error) {
+	client, err := influxdb.NewClient(&influxdb.ClientConfig{
+		Host:     host,
+		Username: username,
+		Password: password,
+		Database: database,
+		IsSecure: isSecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InfluxV1Writer{client: client}, nil
+}
+
+func (w *InfluxV1Writer) Write(points []*write.Point) error {
+	seriesGroup := make([]*influxdb.Series, 0, len(points))
+	for _, p := range points {
+		seriesGroup = append(seriesGroup, pointToSeries(p))
+	}
+	return w.client.WriteSeries(seriesGroup)
+}
+
+func (w *InfluxV1Writer) Close() {}
+
+// pointToSeries flattens a tagged point down to a single-row influxdb.Series,
+// carrying its tags and fields through as plain columns.
+func pointToSeries(p *write.Point) *influxdb.Series {
+	columns := []string{"time"}
+	values := []interface{}{p.Time().UnixNano() / 1000000}
+
+	for _, tag := range p.TagList() {
+		columns = append(columns, tag.Key)
+		values = append(values, tag.Value)
+	}
+	for _, field := range p.FieldList() {
+		columns = append(columns, field.Key)
+		values = append(values, field.Value)
+	}
+
+	return &influxdb.Series{
+		Name:    p.Name(),
+		Columns: columns,
+		Points:  [][]interface{}{values},
+	}
+}
+
+// InfluxV2Writer writes points to an InfluxDB 2.x (or Cloud) server using the
+// synchronous WriteAPIBlocking, authenticating with a token instead of a
+// username/password pair. The blocking API is used instead of the batching
+// WriteAPI so that a write failure is reported directly to the caller, which
+// is what drives output.go's retry logic and metricWriteErrors — the async
+// WriteAPI only ever reports errors on a background channel, with no way to
+// tie one back to the Write call that caused it.
+type InfluxV2Writer struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPIBlocking
+}
+
+// NewInfluxV2Writer connects to an InfluxDB 2.x server.
+func NewInfluxV2Writer(url, token, org, bucket string) *InfluxV2Writer {
+	client := influxdb2.NewClient(url, token)
+	return &InfluxV2Writer{client: client, writeAPI: client.WriteAPIBlocking(org, bucket)}
+}
+
+func (w *InfluxV2Writer) Write(points []*write.Point) error {
+	return w.writeAPI.WritePoint(context.Background(), points...)
+}
+
+func (w *InfluxV2Writer) Close() {
+	w.client.Close()
+}
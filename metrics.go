@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricPacketsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_packets_received_total",
+		Help: "Total number of collectd packets received.",
+	})
+	metricPacketsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_packets_dropped_total",
+		Help: "Total number of collectd metrics dropped, e.g. for an unknown type instance.",
+	})
+	metricPointsWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_points_written_total",
+		Help: "Total number of points successfully written to a registered output.",
+	})
+	metricWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_output_write_errors_total",
+		Help: "Total number of failed write attempts, per registered output.",
+	}, []string{"output"})
+	metricCacheSize = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "proxy_normalize_cache_entries",
+		Help: "Number of entries currently held in the COUNTER/DERIVE normalization cache.",
+	}, func() float64 { return float64(beforeCache.Len()) })
+	metricDockerResolutions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_docker_resolutions_total",
+		Help: "Total number of collectd hostnames resolved to a Docker container name.",
+	})
+	metricCollectdValue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collectd_value",
+		Help: "Most recent value of each collectd metric seen by the proxy.",
+	}, []string{"host", "plugin", "plugin_instance", "type", "type_instance"})
+
+	// collectdValueTracker bounds metricCollectdValue's cardinality; it's
+	// built by setup() once -normalize-ttl has been parsed.
+	collectdValueTracker *collectdGaugeTracker
+)
+
+// collectdGaugeLabels is the label tuple metricCollectdValue is keyed by.
+type collectdGaugeLabels struct {
+	host, plugin, pluginInstance, typ, typeInstance string
+	lastSeen                                        time.Time
+}
+
+// collectdGaugeTracker records when each label tuple passed to
+// metricCollectdValue was last seen, and evicts stale ones so label
+// combinations for hosts that have gone away (e.g. Docker containers
+// rotating through hostnames) don't accumulate in the gauge forever. It
+// mirrors the TTL-eviction half of NormalizerCache. It is safe for
+// concurrent use.
+type collectdGaugeTracker struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]collectdGaugeLabels
+}
+
+// newCollectdGaugeTracker builds a tracker that evicts label tuples idle for
+// longer than ttl (no eviction if ttl <= 0).
+func newCollectdGaugeTracker(ttl time.Duration) *collectdGaugeTracker {
+	return &collectdGaugeTracker{ttl: ttl, seen: make(map[string]collectdGaugeLabels)}
+}
+
+// touch records that the given label tuple was just set on metricCollectdValue.
+func (t *collectdGaugeTracker) touch(host, plugin, pluginInstance, typ, typeInstance string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := host + "." + plugin + "." + pluginInstance + "." + typ + "." + typeInstance
+	t.seen[key] = collectdGaugeLabels{host, plugin, pluginInstance, typ, typeInstance, time.Now()}
+}
+
+// evictExpired drops every label tuple idle for longer than ttl, removing
+// it from metricCollectdValue too so the stale series stops being exported.
+func (t *collectdGaugeTracker) evictExpired() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for key, l := range t.seen {
+		if now.Sub(l.lastSeen) > t.ttl {
+			delete(t.seen, key)
+			metricCollectdValue.DeleteLabelValues(l.host, l.plugin, l.pluginInstance, l.typ, l.typeInstance)
+		}
+	}
+}
+
+// janitor evicts expired label tuples every interval, for the lifetime of
+// the process. It's a no-op when the tracker has no TTL.
+func (t *collectdGaugeTracker) janitor(interval time.Duration) {
+	if t.ttl <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		t.evictExpired()
+	}
+}
+
+func init() {
+	prometheus.MustRegister(
+		metricPacketsReceived,
+		metricPacketsDropped,
+		metricPointsWritten,
+		metricWriteErrors,
+		metricCacheSize,
+		metricDockerResolutions,
+		metricCollectdValue,
+	)
+}
+
+// startMetricsServer serves /metrics (proxy internals plus a gauge per
+// cached collectd metric) on httpListen. It's run in its own goroutine and
+// is not expected to return.
+func startMetricsServer(httpListen string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("metrics listening on %s\n", httpListen)
+	if err := http.ListenAndServe(httpListen, mux); err != nil {
+		log.Fatalf("failed to start metrics server: %v\n", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	outputRetryQueueSize      = 100
+	outputRetryMaxAttempts    = 3
+	outputRetryInitialBackoff = time.Second
+)
+
+// Output is a destination a batch of points can be written to. Multiple
+// outputs can be registered via repeated -output flags, so the same
+// collectd stream can fan out to e.g. InfluxDB and Graphite/Carbon at once.
+type Output interface {
+	Name() string
+	Write(points []*Point) error
+	Close()
+}
+
+// outputFlag collects repeated -output flag values, e.g.
+// -output=influxdb://host:8086 -output=carbon://host:2003.
+type outputFlag []string
+
+func (o *outputFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *outputFlag) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// newOutput constructs the Output named by a -output value of the form
+// "<scheme>://<address>". The address is optional for influxdb, which falls
+// back to the -influx-* flags when omitted.
+func newOutput(spec string) (Output, error) {
+	scheme, address := spec, ""
+	if i := strings.Index(spec, "://"); i >= 0 {
+		scheme, address = spec[:i], spec[i+3:]
+	}
+
+	switch scheme {
+	case "influxdb":
+		return newInfluxOutput(address)
+	case "carbon":
+		return NewCarbonOutput(address)
+	default:
+		return nil, fmt.Errorf("unknown output scheme %q", scheme)
+	}
+}
+
+// outputRunner pairs an Output with a bounded queue and its own goroutine, so
+// a slow or failing output can neither block the collectd ingest loop nor
+// take down the other registered outputs.
+type outputRunner struct {
+	output Output
+	queue  chan []*Point
+	done   chan struct{}
+}
+
+func newOutputRunner(o Output) *outputRunner {
+	r := &outputRunner{output: o, queue: make(chan []*Point, outputRetryQueueSize), done: make(chan struct{})}
+	go r.run()
+	return r
+}
+
+func (r *outputRunner) submit(points []*Point) {
+	select {
+	case r.queue <- points:
+	default:
+		log.Printf("output %s: retry queue full, dropping batch of %d points\n", r.output.Name(), len(points))
+	}
+}
+
+func (r *outputRunner) run() {
+	defer close(r.done)
+	for batch := range r.queue {
+		if err := r.output.Write(batch); err != nil {
+			metricWriteErrors.WithLabelValues(r.output.Name()).Inc()
+			log.Printf("output %s: write failed, retrying: %s\n", r.output.Name(), err)
+			r.retry(batch)
+		} else {
+			metricPointsWritten.Add(float64(len(batch)))
+			if *verbose {
+				log.Printf("[TRACE] output %s wrote %d points\n", r.output.Name(), len(batch))
+			}
+		}
+	}
+}
+
+// retry re-attempts a failed batch with exponential backoff, giving up and
+// dropping it after outputRetryMaxAttempts.
+func (r *outputRunner) retry(batch []*Point) {
+	backoff := outputRetryInitialBackoff
+	for attempt := 1; attempt <= outputRetryMaxAttempts; attempt++ {
+		time.Sleep(backoff)
+		if err := r.output.Write(batch); err == nil {
+			metricPointsWritten.Add(float64(len(batch)))
+			return
+		}
+		metricWriteErrors.WithLabelValues(r.output.Name()).Inc()
+		backoff *= 2
+	}
+	log.Printf("output %s: dropping batch of %d points after %d failed attempts\n", r.output.Name(), len(batch), outputRetryMaxAttempts+1)
+}
+
+// close stops accepting batches, waits for the batches already queued to be
+// written (or dropped after their retries), then closes the output.
+func (r *outputRunner) close() {
+	close(r.queue)
+	<-r.done
+	r.output.Close()
+}
+
+// dispatch fans a batch of points out to every registered output.
+func dispatch(runners []*outputRunner, points []*Point) {
+	for _, r := range runners {
+		r.submit(points)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "time"
+
+// Point is the output-agnostic representation of a single collectd metric,
+// ready to be handed to any Output implementation.
+type Point struct {
+	Name      string
+	Tags      map[string]string
+	Fields    map[string]interface{}
+	Timestamp int64 // milliseconds since epoch, as produced by collectd.Packet.Time()
+}
+
+func newPoint(name string, tags map[string]string, fields map[string]interface{}, timestamp int64) *Point {
+	return &Point{Name: name, Tags: tags, Fields: fields, Timestamp: timestamp}
+}
+
+// timeFromMillis converts the millisecond timestamps used throughout this
+// package into a time.Time.
+func timeFromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
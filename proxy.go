@@ -3,14 +3,11 @@ package main
 import (
 	"flag"
 	"log"
-	"math"
 	"os"
 	"os/signal"
 	"strings"
-	"sync"
 	"time"
 
-	influxdb "github.com/influxdb/influxdb/client"
 	collectd "github.com/paulhammond/gocollectd"
 	"github.com/samalba/dockerclient"
 )
@@ -26,40 +23,44 @@ var (
 	logPath     *string
 	verbose     *bool
 	https       *bool
+	httpListen  *string
 
-	// influxdb options
+	// influxdb v1 options, kept for backward compatibility
 	host      *string
 	username  *string
 	password  *string
 	database  *string
 	normalize *bool
+	schema    *string
 
-	docker *dockerT
+	// influxdb v2 options
+	influxScheme *string
+	influxURL    *string
+	influxOrg    *string
+	influxBucket *string
+	influxToken  *string
 
-	types       Types
-	client      *influxdb.Client
-	beforeCache map[string]CacheEntry
-)
+	docker            *dockerT
+	dockerSock        *string
+	dockerLabelTags   *string
+	dockerLabelPrefix *string
+	dockerLabelAllow  map[string]struct{}
 
-type dockerT struct {
-	sync.Mutex
-	client *dockerclient.DockerClient
-	names  map[string]string
-}
+	outputSpecs outputFlag
 
-// point cache to perform data normalization for COUNTER and DERIVE types
-type CacheEntry struct {
-	Timestamp int64
-	Value     float64
-}
+	normalizeTTL        *time.Duration
+	normalizeMaxEntries *int
 
-// signal handler
-func handleSignals(c chan os.Signal) {
-	// block until a signal is received
-	sig := <-c
+	types       Types
+	beforeCache *NormalizerCache
+)
 
-	log.Printf("exit with a signal: %v\n", sig)
-	os.Exit(1)
+// handleSignals blocks until a signal is received, then forwards it on done
+// rather than exiting itself, so main can flush and close the registered
+// outputs before the process terminates.
+func handleSignals(c chan os.Signal, done chan os.Signal) {
+	sig := <-c
+	done <- sig
 }
 
 func init() {
@@ -70,22 +71,49 @@ func init() {
 	typesdbPath = flag.String("typesdb", "types.db", "path to Collectd's types.db")
 	logPath = flag.String("logfile", "proxy.log", "path to log file")
 	verbose = flag.Bool("verbose", false, "true if you need to trace the requests")
+	httpListen = flag.String("http-listen", ":9103", "address to serve /metrics (Prometheus exposition) on")
 
-	// influxdb options
+	// influxdb v1 options, kept for backward compatibility
 	host = flag.String("influxdb", "localhost:8086", "host:port for influxdb")
 	username = flag.String("username", "root", "username for influxdb")
 	password = flag.String("password", "root", "password for influxdb")
 	database = flag.String("database", "", "database for influxdb")
 	normalize = flag.Bool("normalize", true, "true if you need to normalize data for COUNTER and DERIVE types (over time)")
+	normalizeTTL = flag.Duration("normalize-ttl", 10*time.Minute, "how long an idle entry stays in the COUNTER/DERIVE normalization cache before it's evicted")
+	normalizeMaxEntries = flag.Int("normalize-max-entries", 100000, "maximum number of entries the COUNTER/DERIVE normalization cache holds before evicting the least-recently-used one")
 	https = flag.Bool("https", false, "true if you want the influxdb client to connect over https")
+	schema = flag.String("schema", schemaLegacy, "point schema to emit: '"+schemaLegacy+"' (one series per metric, hostname as a column) or '"+schemaTagged+"' (single measurement, full tag set)")
+
+	// influxdb v2 options; setting -influx-token selects the v2 writer
+	influxScheme = flag.String("influx-scheme", "http", "scheme for influxdb v2 url (http or https)")
+	influxURL = flag.String("influx-url", "", "url for influxdb v2, e.g. http://localhost:8086 (overrides -influx-scheme/-influxdb)")
+	influxOrg = flag.String("influx-org", "", "organization for influxdb v2")
+	influxBucket = flag.String("influx-bucket", "", "bucket for influxdb v2")
+	influxToken = flag.String("influx-token", "", "auth token for influxdb v2; if set, the v2 write API is used instead of v1")
 
 	// docker options
-	dockerSock := flag.String("docker", "", "Docker socket e.g. unix:///var/run/docker.sock")
+	dockerSock = flag.String("docker", "", "Docker socket e.g. unix:///var/run/docker.sock")
+	dockerLabelTags = flag.String("docker-label-tags", "", "comma-separated allowlist of Docker container label keys to attach as tags")
+	dockerLabelPrefix = flag.String("docker-label-prefix", "", "attach any Docker container label whose key has this prefix as a tag")
 
-	flag.Parse()
+	// output options; repeatable, defaults to a single influxdb output built
+	// from the -influx-* flags above when omitted
+	flag.Var(&outputSpecs, "output", "output backend to write to, may be repeated (e.g. -output=influxdb://host:8086 -output=carbon://host:2003)")
+}
 
+// setup finishes initialization that depends on parsed flag values. It runs
+// after flag.Parse(), which main() calls rather than init() so that
+// `go test` can register its own flags first.
+func setup() {
 	var err error
 
+	dockerLabelAllow = make(map[string]struct{})
+	for _, k := range strings.Split(*dockerLabelTags, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			dockerLabelAllow[k] = struct{}{}
+		}
+	}
+
 	if *dockerSock != "" {
 		docker = &dockerT{}
 		// Init the client
@@ -104,7 +132,14 @@ func init() {
 		}()
 	}
 
-	beforeCache = make(map[string]CacheEntry)
+	beforeCache = NewNormalizerCache(*normalizeTTL, *normalizeMaxEntries)
+	go beforeCache.janitor(time.Minute)
+
+	// reuse the normalization cache's TTL to bound metricCollectdValue's
+	// cardinality the same way, for the same reason (e.g. Docker-resolved
+	// hostnames rotating through the gauge)
+	collectdValueTracker = newCollectdGaugeTracker(*normalizeTTL)
+	go collectdValueTracker.janitor(time.Minute)
 
 	// read types.db
 	types, err = ParseTypesDB(*typesdbPath)
@@ -114,6 +149,9 @@ func init() {
 }
 
 func main() {
+	flag.Parse()
+	setup()
+
 	logFile, err := os.OpenFile(*logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatalf("failed to open file: %v\n", err)
@@ -121,22 +159,31 @@ func main() {
 	log.SetOutput(logFile)
 	defer logFile.Close()
 
-	// make influxdb client
-	client, err = influxdb.NewClient(&influxdb.ClientConfig{
-		Host:     *host,
-		Username: *username,
-		Password: *password,
-		Database: *database,
-		IsSecure: *https,
-	})
-	if err != nil {
-		log.Fatalf("failed to make a influxdb client: %v\n", err)
+	// set up the registered outputs (defaulting to a single influxdb output
+	// for backward compatibility if -output was never given), each behind
+	// its own retry queue
+	specs := []string(outputSpecs)
+	if len(specs) == 0 {
+		specs = []string{"influxdb"}
+	}
+	runners := make([]*outputRunner, 0, len(specs))
+	for _, spec := range specs {
+		o, err := newOutput(spec)
+		if err != nil {
+			log.Fatalf("failed to create output %q: %v\n", spec, err)
+		}
+		runners = append(runners, newOutputRunner(o))
 	}
 
-	// register a signal handler
+	go startMetricsServer(*httpListen)
+
+	// register a signal handler; handleSignals only forwards the signal, so
+	// the shutdown case below gets a chance to flush and close the
+	// registered outputs before the process exits
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, os.Interrupt, os.Kill)
-	go handleSignals(sc)
+	shutdown := make(chan os.Signal, 1)
+	go handleSignals(sc, shutdown)
 
 	// make channel for collectd
 	c := make(chan collectd.Packet, packetChannelSize)
@@ -145,37 +192,41 @@ func main() {
 	go collectd.Listen("0.0.0.0:"+*proxyPort, c)
 	log.Printf("proxy started on %s\n", *proxyPort)
 	timer := time.Now()
-	seriesGroup := make([]*influxdb.Series, 0)
-	for packet := range c {
-		seriesGroup = append(seriesGroup, processPacket(packet)...)
-
-		if time.Since(timer) < influxWriteInterval && len(seriesGroup) < influxWriteLimit {
-			continue
-		} else {
+	seriesGroup := make([]*Point, 0)
+	for {
+		select {
+		case packet := <-c:
+			seriesGroup = append(seriesGroup, processPacket(packet)...)
+
+			if time.Since(timer) < influxWriteInterval && len(seriesGroup) < influxWriteLimit {
+				continue
+			}
 			if len(seriesGroup) > 0 {
-				go backendWriter(seriesGroup)
-				seriesGroup = make([]*influxdb.Series, 0)
+				dispatch(runners, seriesGroup)
+				seriesGroup = make([]*Point, 0)
 			}
 			timer = time.Now()
-		}
-	}
-}
 
-func backendWriter(seriesGroup []*influxdb.Series) {
-	if err := client.WriteSeries(seriesGroup); err != nil {
-		log.Printf("failed to write series group to influxdb: %s\n", err)
-	}
-	if *verbose {
-		log.Printf("[TRACE] wrote %d series\n", len(seriesGroup))
+		case sig := <-shutdown:
+			log.Printf("exit with a signal: %v\n", sig)
+			if len(seriesGroup) > 0 {
+				dispatch(runners, seriesGroup)
+			}
+			for _, r := range runners {
+				r.close()
+			}
+			os.Exit(0)
+		}
 	}
 }
 
-func processPacket(packet collectd.Packet) []*influxdb.Series {
+func processPacket(packet collectd.Packet) []*Point {
 	if *verbose {
 		log.Printf("[TRACE] got a packet: %v\n", packet)
 	}
+	metricPacketsReceived.Inc()
 
-	var seriesGroup []*influxdb.Series
+	var seriesGroup []*Point
 	// for all metrics in the packet
 	for i, _ := range packet.ValueNames() {
 		values, _ := packet.ValueNumbers()
@@ -186,37 +237,44 @@ func processPacket(packet collectd.Packet) []*influxdb.Series {
 		// pass the unknowns
 		if t == nil && packet.TypeInstance == "" {
 			log.Printf("unknown type instance on %s\n", packet.Plugin)
+			metricPacketsDropped.Inc()
 			continue
 		}
 
 		// as hostname contains commas, let's replace them
 		hostName := strings.Replace(packet.Hostname, ".", "_", -1)
+		containerID := hostName
 
-		// Try and resolve Docker container ID to real hostname
+		// Try and resolve Docker container ID to real hostname, and pick up
+		// any of its labels that are configured to ride along as tags
+		var dockerTags map[string]string
 		if docker != nil {
 			docker.Lock()
 			if realName, ok := docker.names[hostName]; ok {
 				hostName = realName
+				metricDockerResolutions.Inc()
 			}
 			docker.Unlock()
+			dockerTags = docker.labelTags(containerID)
+		}
+
+		// fall back to the ds name from types.db when there's no explicit
+		// TypeInstance, e.g. cpu's "user"/"idle"/"system"
+		typeInstance := packet.TypeInstance
+		if typeInstance == "" && t != nil {
+			typeInstance = t[i]
 		}
 
-		// if there's a PluginInstance, use it
 		pluginName := packet.Plugin
 		if packet.PluginInstance != "" {
 			pluginName += "-" + packet.PluginInstance
 		}
-
-		// if there's a TypeInstance, use it
 		typeName := packet.Type
-		if packet.TypeInstance != "" {
-			typeName += "-" + packet.TypeInstance
-		} else if t != nil {
-			typeName += "-" + t[i]
+		if typeInstance != "" {
+			typeName += "-" + typeInstance
 		}
 
 		cacheKey := hostName + "." + pluginName + "." + typeName
-		name := pluginName + "." + typeName
 
 		// influxdb stuffs
 		timestamp := packet.Time().UnixNano() / 1000000
@@ -225,60 +283,33 @@ func processPacket(packet collectd.Packet) []*influxdb.Series {
 		readyToSend := true
 		normalizedValue := value
 
-		if *normalize && dataType == collectd.TypeCounter || dataType == collectd.TypeDerive {
-			if before, ok := beforeCache[cacheKey]; ok && !math.IsNaN(before.Value) {
-				// normalize over time
-				if timestamp-before.Timestamp > 0 {
-					normalizedValue = (value - before.Value) / float64((timestamp-before.Timestamp)/1000)
-				} else {
-					normalizedValue = value - before.Value
-				}
+		if *normalize && (dataType == collectd.TypeCounter || dataType == collectd.TypeDerive) {
+			if v, ok := beforeCache.normalize(cacheKey, value, timestamp, dataType == collectd.TypeCounter); ok {
+				normalizedValue = v
 			} else {
-				// skip current data if there's no initial entry
+				// no prior sample, or a COUNTER that wrapped/reset
 				readyToSend = false
 			}
-			entry := CacheEntry{
-				Timestamp: timestamp,
-				Value:     value,
-			}
-			beforeCache[cacheKey] = entry
 		}
 
 		if readyToSend {
-			series := &influxdb.Series{
-				Name:    name,
-				Columns: []string{"time", "value", "host"},
-				Points: [][]interface{}{
-					[]interface{}{timestamp, normalizedValue, hostName},
-				},
-			}
+			point := buildPoint(pointFields{
+				host:           hostName,
+				plugin:         packet.Plugin,
+				pluginInstance: packet.PluginInstance,
+				typ:            packet.Type,
+				typeInstance:   typeInstance,
+				value:          normalizedValue,
+				timestamp:      timestamp,
+				extraTags:      dockerTags,
+			})
 			if *verbose {
-				log.Printf("[TRACE] ready to send series: %v\n", series)
+				log.Printf("[TRACE] ready to send point: %v\n", point)
 			}
-			seriesGroup = append(seriesGroup, series)
+			metricCollectdValue.WithLabelValues(hostName, packet.Plugin, packet.PluginInstance, packet.Type, typeInstance).Set(normalizedValue)
+			collectdValueTracker.touch(hostName, packet.Plugin, packet.PluginInstance, packet.Type, typeInstance)
+			seriesGroup = append(seriesGroup, point)
 		}
 	}
 	return seriesGroup
 }
-
-func (d *dockerT) updateNames() error {
-	containers, err := d.client.ListContainers(true, true, "")
-	if err != nil {
-		return err
-	}
-	d.Lock()
-	defer d.Unlock()
-	d.names = make(map[string]string)
-	for _, c := range containers {
-		info, err := d.client.InspectContainer(c.Id)
-		if err != nil {
-			return err
-		}
-
-		if info.State.Running {
-			d.names[c.Id] = strings.TrimPrefix(c.Names[0], "/")
-		}
-	}
-
-	return nil
-}
@@ -0,0 +1,82 @@
+package main
+
+const (
+	schemaLegacy = "legacy"
+	schemaTagged = "tagged"
+)
+
+// taggedMeasurement is the single measurement name used by the tagged
+// schema; all collectd data lands here, distinguished by tags instead of by
+// measurement name.
+const taggedMeasurement = "collectd"
+
+// pointFields is one collectd metric after hostname resolution and optional
+// normalization, independent of which schema it will be encoded under.
+type pointFields struct {
+	host           string
+	plugin         string
+	pluginInstance string
+	typ            string
+	typeInstance   string
+	value          float64
+	timestamp      int64
+	// extraTags carries additional tags (e.g. resolved Docker labels) that
+	// ride along regardless of schema. It never overrides a tag the schema
+	// itself sets.
+	extraTags map[string]string
+}
+
+// buildPoint encodes f according to the -schema flag.
+func buildPoint(f pointFields) *Point {
+	if *schema == schemaTagged {
+		return buildTaggedPoint(f)
+	}
+	return buildLegacyPoint(f)
+}
+
+// buildLegacyPoint reproduces the original one-series-per-metric layout:
+// the series name is "<plugin>[-<plugin_instance>].<type>[-<type_instance>]"
+// and the hostname is carried as a "host" column.
+func buildLegacyPoint(f pointFields) *Point {
+	name := withInstance(f.plugin, f.pluginInstance) + "." + withInstance(f.typ, f.typeInstance)
+	tags := map[string]string{"host": f.host}
+	mergeTags(tags, f.extraTags)
+	return newPoint(name, tags, map[string]interface{}{"value": f.value}, f.timestamp)
+}
+
+func withInstance(name, instance string) string {
+	if instance != "" {
+		return name + "-" + instance
+	}
+	return name
+}
+
+// mergeTags copies src into dst, skipping any key dst already has.
+func mergeTags(dst, src map[string]string) {
+	for k, v := range src {
+		if _, exists := dst[k]; !exists {
+			dst[k] = v
+		}
+	}
+}
+
+// buildTaggedPoint emits every metric under a single "collectd" measurement,
+// tagged with hostname, plugin, plugin_instance, type and type_instance, so
+// cardinality lives in tag values rather than in distinct series/measurement
+// names. This matches the tag-based data model used by Telegraf's collectd
+// input and by InfluxDB v2.
+func buildTaggedPoint(f pointFields) *Point {
+	tags := map[string]string{
+		"host":   f.host,
+		"plugin": f.plugin,
+		"type":   f.typ,
+	}
+	if f.pluginInstance != "" {
+		tags["plugin_instance"] = f.pluginInstance
+	}
+	if f.typeInstance != "" {
+		tags["type_instance"] = f.typeInstance
+	}
+	mergeTags(tags, f.extraTags)
+	return newPoint(taggedMeasurement, tags, map[string]interface{}{"value": f.value}, f.timestamp)
+}
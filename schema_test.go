@@ -0,0 +1,143 @@
+package main
+
+import "testing"
+
+func TestBuildLegacyPoint(t *testing.T) {
+	cases := []struct {
+		name       string
+		fields     pointFields
+		wantName   string
+		wantTags   map[string]string
+		wantValue  interface{}
+	}{
+		{
+			name: "cpu",
+			fields: pointFields{
+				host: "web01", plugin: "cpu", pluginInstance: "0",
+				typ: "cpu", typeInstance: "user", value: 12.5, timestamp: 1000,
+			},
+			wantName:  "cpu-0.cpu-user",
+			wantTags:  map[string]string{"host": "web01"},
+			wantValue: 12.5,
+		},
+		{
+			name: "interface",
+			fields: pointFields{
+				host: "web01", plugin: "interface", pluginInstance: "",
+				typ: "if_octets", typeInstance: "", value: 4096, timestamp: 1000,
+			},
+			wantName:  "interface.if_octets",
+			wantTags:  map[string]string{"host": "web01"},
+			wantValue: float64(4096),
+		},
+		{
+			name: "df",
+			fields: pointFields{
+				host: "web01", plugin: "df", pluginInstance: "root",
+				typ: "df_complex", typeInstance: "free", value: 1e9, timestamp: 1000,
+			},
+			wantName:  "df-root.df_complex-free",
+			wantTags:  map[string]string{"host": "web01"},
+			wantValue: 1e9,
+		},
+		{
+			name: "disk",
+			fields: pointFields{
+				host: "web01", plugin: "disk", pluginInstance: "sda",
+				typ: "disk_octets", typeInstance: "", value: 2048, timestamp: 1000,
+			},
+			wantName:  "disk-sda.disk_octets",
+			wantTags:  map[string]string{"host": "web01"},
+			wantValue: float64(2048),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := buildLegacyPoint(c.fields)
+			if p.Name != c.wantName {
+				t.Errorf("Name = %q, want %q", p.Name, c.wantName)
+			}
+			if len(p.Tags) != len(c.wantTags) {
+				t.Errorf("tags = %v, want %v", p.Tags, c.wantTags)
+			}
+			for k, v := range c.wantTags {
+				if p.Tags[k] != v {
+					t.Errorf("tag %q = %q, want %q", k, p.Tags[k], v)
+				}
+			}
+			if len(p.Fields) != 1 || p.Fields["value"] != c.wantValue {
+				t.Errorf("fields = %v, want single value field %v", p.Fields, c.wantValue)
+			}
+		})
+	}
+}
+
+func TestBuildTaggedPoint(t *testing.T) {
+	cases := []struct {
+		name     string
+		fields   pointFields
+		wantTags map[string]string
+	}{
+		{
+			name: "cpu",
+			fields: pointFields{
+				host: "web01", plugin: "cpu", pluginInstance: "0",
+				typ: "cpu", typeInstance: "user", value: 12.5, timestamp: 1000,
+			},
+			wantTags: map[string]string{
+				"host": "web01", "plugin": "cpu", "plugin_instance": "0",
+				"type": "cpu", "type_instance": "user",
+			},
+		},
+		{
+			name: "interface",
+			fields: pointFields{
+				host: "web01", plugin: "interface", pluginInstance: "",
+				typ: "if_octets", typeInstance: "", value: 4096, timestamp: 1000,
+			},
+			wantTags: map[string]string{
+				"host": "web01", "plugin": "interface", "type": "if_octets",
+			},
+		},
+		{
+			name: "df",
+			fields: pointFields{
+				host: "web01", plugin: "df", pluginInstance: "root",
+				typ: "df_complex", typeInstance: "free", value: 1e9, timestamp: 1000,
+			},
+			wantTags: map[string]string{
+				"host": "web01", "plugin": "df", "plugin_instance": "root",
+				"type": "df_complex", "type_instance": "free",
+			},
+		},
+		{
+			name: "disk",
+			fields: pointFields{
+				host: "web01", plugin: "disk", pluginInstance: "sda",
+				typ: "disk_octets", typeInstance: "", value: 2048, timestamp: 1000,
+			},
+			wantTags: map[string]string{
+				"host": "web01", "plugin": "disk", "plugin_instance": "sda",
+				"type": "disk_octets",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := buildTaggedPoint(c.fields)
+			if p.Name != taggedMeasurement {
+				t.Errorf("Name = %q, want %q", p.Name, taggedMeasurement)
+			}
+			if len(p.Tags) != len(c.wantTags) {
+				t.Errorf("tags = %v, want %v", p.Tags, c.wantTags)
+			}
+			for k, v := range c.wantTags {
+				if p.Tags[k] != v {
+					t.Errorf("tag %q = %q, want %q", k, p.Tags[k], v)
+				}
+			}
+		})
+	}
+}